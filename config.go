@@ -3,10 +3,16 @@ package awslambda
 import (
 	"encoding/json"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/mholt/caddy"
@@ -23,15 +29,45 @@ type Config struct {
 	AwsSecret string
 	// AWS Region. If omitted, AWS_REGION env var is used.
 	AwsRegion string
+
+	// Name of a profile to load from the shared AWS config/credentials
+	// files. Ignored if AwsAccess is set.
+	AwsProfile string
+	// Path to a shared credentials file to load AwsProfile from. If
+	// omitted, the SDK's default location (~/.aws/credentials) is used.
+	AwsSharedCredentialsFile string
+	// ARN of an IAM role to assume before invoking Lambda. When set, the
+	// credentials resolved from AwsAccess/AwsSecret, AwsProfile/
+	// AwsSharedCredentialsFile, or the SDK's default EC2/ECS role chain
+	// are used as the base session for an stscreds.AssumeRoleProvider (or
+	// stscreds.WebIdentityRoleProvider if AwsWebIdentityTokenFile is set).
+	AwsRoleArn string
+	// Session name to use when assuming AwsRoleArn. If omitted, the SDK
+	// generates one.
+	AwsRoleSessionName string
+	// Optional external ID to pass when assuming AwsRoleArn.
+	AwsExternalID string
+	// Path to a web identity (e.g. OIDC/IRSA) token file. When set
+	// together with AwsRoleArn, the role is assumed via
+	// stscreds.NewWebIdentityCredentials instead of the static/shared/
+	// default credential chain.
+	AwsWebIdentityTokenFile string
+
 	// Optional qualifier to use on Invoke requests.
 	// This can be used to pin a configuration to a particular alias (e.g. 'prod' or 'dev')
 	Qualifier string
-	// Function name include rules. Prefix and suffix '*' globs are supported.
-	// Functions matching *any* of these rules will be proxied.
+	// Function name include rules. Prefix and suffix '*' globs are
+	// supported, or, with a leading '~', a regexp.Regexp (e.g.
+	// '~^api-(v\d+)-(.*)$'), optionally followed by a "-> replacement"
+	// clause that rewrites the matched name (regexp.Regexp.ReplaceAllString
+	// syntax, e.g. '~^api-(v\d+)-(.*)$ -> prod-$2') before it is sent to
+	// Lambda. Functions matching *any* of these rules will be proxied.
 	// If Include is empty, all function names will be allowed (unless explicitly excluded).
 	Include []string
-	// Function name exclude rules. Prefix and suffix '*" globs are supported.
-	// Functions matching *any* of these rules will be excluded, and not proxied.
+	// Function name exclude rules. Same glob/regexp syntax as Include; any
+	// "-> replacement" clause is ignored since excluded names are never
+	// invoked. Functions matching *any* of these rules will be excluded,
+	// and not proxied.
 	// If Exclude is empty, no exclude rules will be applied.
 	Exclude []string
 	// Optional strings to prepend or append to the parsed function name from the URL
@@ -59,47 +95,175 @@ type Config struct {
 	// headers to set in the upstream "headers" array - caddy placeholders work here
 	UpstreamHeaders map[string][]string
 
-	invoker Invoker
+	// Headers to set on the HTTP response after the Lambda reply has been
+	// parsed - caddy placeholders work here, plus {lambda_status} and
+	// {lambda_function}.
+	DownstreamHeaders map[string][]string
+
+	// Path to a Go text/template file applied to the decoded reply body
+	// before it is written to the response. The template is executed with
+	// a responseTemplateData value ({{.Body}}, {{.StatusCode}},
+	// {{.FunctionName}}).
+	ResponseTemplate string
+
+	// Content-Type to set on the response when the Lambda reply (or
+	// ResponseTemplate output) didn't already set one. Useful for
+	// raw-text Lambdas that don't set response headers themselves.
+	ContentTypeDefault string
+
+	// PayloadFormat selects the JSON shape used for the Lambda Invoke
+	// Payload and for decoding its reply. An empty value is equivalent to
+	// PayloadFormatDefault. PayloadFormatAPIGateway and
+	// PayloadFormatAPIGatewayV2 marshal the event shape Lambda would
+	// receive from a real API Gateway Proxy Integration, so existing
+	// APIGateway-targeted functions can be invoked unmodified.
+	PayloadFormat string
+
+	// InvocationType selects the Lambda Invoke InvocationType
+	// (lambda.InvocationTypeRequestResponse, lambda.InvocationTypeEvent or
+	// lambda.InvocationTypeDryRun) used for requests handled by this
+	// Config. An empty value is equivalent to
+	// lambda.InvocationTypeRequestResponse. AsyncFunctions rules are
+	// checked first and take precedence over InvocationType.
+	InvocationType string
+
+	// Function name rules (the prefix/suffix '*' globs of Include/Exclude;
+	// the '~' regexp form is not supported here). Functions
+	// matching any of these rules are always invoked with
+	// lambda.InvocationTypeEvent, regardless of InvocationType. This lets
+	// a Config mix synchronous request/response functions with
+	// fire-and-forget background workers.
+	AsyncFunctions []string
+
+	// Maximum duration to wait for a single Invoke call to complete,
+	// applied as the underlying HTTP client's timeout. Zero means no
+	// explicit timeout beyond the AWS SDK's own defaults.
+	InvokeTimeout time.Duration
+	// Maximum number of retry attempts for a single Invoke call. Only
+	// lambda.ErrCodeTooManyRequestsException and transient 5xx errors are
+	// retried; zero disables these retries. When both MaxRetries and
+	// BreakerFailures are zero, the AWS SDK's default retry behavior is
+	// left in place.
+	MaxRetries int
+	// Backoff strategy used between retry attempts: RetryBackoffConstant,
+	// RetryBackoffExponential or RetryBackoffJittered. Defaults to
+	// RetryBackoffConstant.
+	RetryBackoff string
+	// Number of Invoke failures for a given function, within a one minute
+	// sliding window, that trips the circuit breaker for that function.
+	// Zero disables the breaker.
+	BreakerFailures int
+	// How long the circuit breaker stays open, failing Invoke calls for
+	// the tripped function with a 503 rather than calling Lambda, before
+	// allowing a single half-open probe request through.
+	BreakerCooldown time.Duration
+
+	invoker      Invoker
+	responseTmpl *template.Template
+
+	ruleMu          sync.Mutex
+	includeRulesSrc []string
+	includeRules    []*funcNameRule
+	excludeRulesSrc []string
+	excludeRules    []*funcNameRule
 }
 
-// AcceptsFunction tests whether the given function name is supported for
-// this configuration by applying the Include and Exclude rules.
+// ResolveFunction applies the Include and Exclude rules to name and returns
+// the name to invoke on Lambda along with whether name is accepted by this
+// configuration. The returned name differs from name only when an Include
+// rule is a regexp with a "-> replacement" clause that matched.
 //
 // Some additional lightweight sanity tests are also performed.  For example,
 // empty strings and names containing periods (prohibited by AWS Lambda) will
 // return false, but there is no attempt to ensure that all AWS Lambda naming
 // rules are validated.  That is, some invalid names could be passed through.
-//
-func (c *Config) AcceptsFunction(name string) bool {
+func (c *Config) ResolveFunction(name string) (string, bool) {
 	if name == "" || strings.Index(name, ".") >= 0 {
-		return false
+		return name, false
 	}
 
-	if len(c.Include) > 0 {
+	resolved := name
+	includeRules := c.getIncludeRules()
+	if len(includeRules) > 0 {
 		found := false
-		for _, k := range c.Include {
-			if matchGlob(name, k) {
+		for _, rule := range includeRules {
+			if newName, ok := rule.match(name); ok {
+				resolved = newName
 				found = true
 				break
 			}
 		}
 		if !found {
-			return false
+			return name, false
 		}
 	}
 
-	for _, k := range c.Exclude {
-		if matchGlob(name, k) {
-			return false
+	for _, rule := range c.getExcludeRules() {
+		if _, ok := rule.match(name); ok {
+			return name, false
 		}
 	}
 
+	return resolved, true
+}
+
+// getIncludeRules returns Include parsed into *funcNameRule, caching the
+// compiled rules on c and only re-parsing them when Include has changed
+// since the last call - ResolveFunction runs on every proxied request, and
+// Include/Exclude entries are most often regexps, so recompiling them per
+// request would undo the work the circuit breaker/retry policy does to
+// protect worker goroutines under load.
+func (c *Config) getIncludeRules() []*funcNameRule {
+	c.ruleMu.Lock()
+	defer c.ruleMu.Unlock()
+	if !stringSlicesEqual(c.includeRulesSrc, c.Include) {
+		c.includeRules = parseFuncNameRules(c.Include)
+		c.includeRulesSrc = append([]string(nil), c.Include...)
+	}
+	return c.includeRules
+}
+
+// getExcludeRules is the Exclude equivalent of getIncludeRules.
+func (c *Config) getExcludeRules() []*funcNameRule {
+	c.ruleMu.Lock()
+	defer c.ruleMu.Unlock()
+	if !stringSlicesEqual(c.excludeRulesSrc, c.Exclude) {
+		c.excludeRules = parseFuncNameRules(c.Exclude)
+		c.excludeRulesSrc = append([]string(nil), c.Exclude...)
+	}
+	return c.excludeRules
+}
+
+// stringSlicesEqual reports whether a and b hold the same strings in the
+// same order.
+func stringSlicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, v := range a {
+		if v != b[i] {
+			return false
+		}
+	}
 	return true
 }
 
+// AcceptsFunction tests whether the given function name is supported for
+// this configuration by applying the Include and Exclude rules. See
+// ResolveFunction for the full matching and rewrite semantics.
+func (c *Config) AcceptsFunction(name string) bool {
+	_, ok := c.ResolveFunction(name)
+	return ok
+}
+
 // ToAwsConfig returns a new *aws.Config instance using the AWS related values on Config.
 // If AwsRegion is empty, the AWS_REGION env var is used.
-// If AwsAccess is empty, the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY env vars are used.
+// If AwsAccess is set, it (and AwsSecret) are used as static credentials.
+// Otherwise, if AwsProfile or AwsSharedCredentialsFile is set, credentials are
+// loaded from the shared credentials file/profile. If neither is set, the
+// SDK's default credential chain (env vars, shared config, EC2/ECS role) is
+// used. AwsRoleArn (handled in initLambdaClient) is layered on top of
+// whichever of these provides the base credentials.
 func (c *Config) ToAwsConfig() *aws.Config {
 	awsConf := aws.NewConfig()
 	if c.AwsRegion != "" {
@@ -109,6 +273,10 @@ func (c *Config) ToAwsConfig() *aws.Config {
 		awsConf.WithCredentials(credentials.NewStaticCredentials(
 			c.AwsAccess, c.AwsSecret, "",
 		))
+	} else if c.AwsProfile != "" || c.AwsSharedCredentialsFile != "" {
+		awsConf.WithCredentials(credentials.NewSharedCredentials(
+			c.AwsSharedCredentialsFile, c.AwsProfile,
+		))
 	}
 	return awsConf
 }
@@ -138,15 +306,70 @@ func (c *Config) ParseFunction(path string) string {
 // Otherwise an InvokeInput is returned with all fields populated based on the
 // http.Request, and the NameAppend and NamePrepend rules applied (if any).
 func (c *Config) MaybeToInvokeInput(r *http.Request) (*lambda.InvokeInput, error) {
-	// Verify that parsed function name is allowed based on Config rules
-	funcName := c.Single
-	if funcName == "" {
-		funcName = c.ParseFunction(r.URL.Path)
-		if !c.AcceptsFunction(funcName) {
+	// parsedName is the function name as parsed from the URL, used to
+	// strip the path prefix. funcName is the (possibly rewritten) name
+	// actually invoked on Lambda.
+	parsedName := c.Single
+	funcName := parsedName
+	if parsedName == "" {
+		parsedName = c.ParseFunction(r.URL.Path)
+		resolved, ok := c.ResolveFunction(parsedName)
+		if !ok {
 			return nil, nil
 		}
+		funcName = resolved
+	}
+
+	var payload []byte
+	var err error
+	switch c.PayloadFormat {
+	case PayloadFormatAPIGateway, PayloadFormatAPIGatewayV2:
+		payload, err = c.toAPIGatewayPayload(r, parsedName)
+	default:
+		payload, err = c.toDefaultPayload(r, parsedName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if c.NamePrepend != "" {
+		funcName = c.NamePrepend + funcName
+	}
+	if c.NameAppend != "" {
+		funcName = funcName + c.NameAppend
+	}
+
+	invocationType := c.resolveInvocationType(funcName)
+	input := &lambda.InvokeInput{
+		FunctionName:   &funcName,
+		Payload:        payload,
+		InvocationType: &invocationType,
+	}
+	if c.Qualifier != "" {
+		input.Qualifier = &c.Qualifier
+	}
+	return input, nil
+}
+
+// resolveInvocationType returns the lambda.InvocationType to use for
+// funcName. AsyncFunctions is checked first: a match always yields
+// lambda.InvocationTypeEvent regardless of InvocationType. Otherwise
+// InvocationType is used, defaulting to lambda.InvocationTypeRequestResponse.
+func (c *Config) resolveInvocationType(funcName string) string {
+	for _, rule := range c.AsyncFunctions {
+		if matchGlob(funcName, rule) {
+			return lambda.InvocationTypeEvent
+		}
+	}
+	if c.InvocationType != "" {
+		return c.InvocationType
 	}
+	return lambda.InvocationTypeRequestResponse
+}
 
+// toDefaultPayload marshals r into the plugin's native RequestMeta/Request
+// envelope, applying StripPathPrefix and the header_upstream directive.
+func (c *Config) toDefaultPayload(r *http.Request, funcName string) ([]byte, error) {
 	req, err := NewRequest(r)
 	if err != nil {
 		return nil, err
@@ -155,48 +378,122 @@ func (c *Config) MaybeToInvokeInput(r *http.Request) (*lambda.InvokeInput, error
 		req.Meta.Path = c.stripPathPrefix(req.Meta.Path, funcName)
 	}
 
-	if len(c.UpstreamHeaders) > 0 {
-		// inject upstream headers defined with the header_upstream directive into req.Meta.Headers
-		// uses caddy's integrated replacer for placeholder replacement (https://caddyserver.com/docs/placeholders)
-		replInt := r.Context().Value(httpserver.ReplacerCtxKey)
-		replacer := replInt.(httpserver.Replacer)
+	for k, v := range c.UpstreamHeaders {
+		req.Meta.Headers[strings.ToLower(k)] = c.replacedHeaderValues(r, v)
+	}
+
+	return json.Marshal(req)
+}
+
+// toAPIGatewayPayload marshals r into the API Gateway Proxy Integration
+// event shape (PayloadFormatAPIGateway) or the HTTP API payload format
+// 2.0 shape (PayloadFormatAPIGatewayV2), applying StripPathPrefix and the
+// header_upstream directive.
+func (c *Config) toAPIGatewayPayload(r *http.Request, funcName string) ([]byte, error) {
+	if c.PayloadFormat == PayloadFormatAPIGatewayV2 {
+		req, err := NewAPIGatewayV2Request(r)
+		if err != nil {
+			return nil, err
+		}
+		if c.StripPathPrefix {
+			path := c.stripPathPrefix(req.RawPath, funcName)
+			req.RawPath = path
+			req.RequestContext.HTTP.Path = path
+		}
 		for k, v := range c.UpstreamHeaders {
-			newValue := make([]string, len(v))
-			for i, v := range v {
-				newValue[i] = replacer.Replace(v)
-			}
-			req.Meta.Headers[strings.ToLower(k)] = newValue
+			req.Headers[strings.ToLower(k)] = strings.Join(c.replacedHeaderValues(r, v), ",")
 		}
+		return json.Marshal(req)
 	}
 
-	payload, err := json.Marshal(req)
+	req, err := NewAPIGatewayRequest(r)
 	if err != nil {
 		return nil, err
 	}
+	if c.StripPathPrefix {
+		req.Path = c.stripPathPrefix(req.Path, funcName)
+	}
+	for k, v := range c.UpstreamHeaders {
+		k = strings.ToLower(k)
+		newValue := c.replacedHeaderValues(r, v)
+		req.MultiValueHeaders[k] = newValue
+		req.Headers[k] = newValue[len(newValue)-1]
+	}
+	return json.Marshal(req)
+}
 
-	if c.NamePrepend != "" {
-		funcName = c.NamePrepend + funcName
+// replacedHeaderValues applies caddy's placeholder replacer
+// (https://caddyserver.com/docs/placeholders) to each of values, as used
+// by the header_upstream directive.
+func (c *Config) replacedHeaderValues(r *http.Request, values []string) []string {
+	replInt := r.Context().Value(httpserver.ReplacerCtxKey)
+	replacer := replInt.(httpserver.Replacer)
+	out := make([]string, len(values))
+	for i, v := range values {
+		out[i] = replacer.Replace(v)
 	}
-	if c.NameAppend != "" {
-		funcName = funcName + c.NameAppend
+	return out
+}
+
+func (c *Config) initLambdaClient() error {
+	awsConf := c.ToAwsConfig()
+	if c.InvokeTimeout > 0 {
+		awsConf.WithHTTPClient(&http.Client{Timeout: c.InvokeTimeout})
+	}
+	wrapResilient := c.MaxRetries > 0 || c.BreakerFailures > 0
+	if wrapResilient {
+		// Retries are enforced by resilientInvoker below, not the AWS
+		// SDK's own retryer - leaving the SDK's default retries enabled
+		// here would retry the same throttling/5xx errors a second time
+		// underneath resilientInvoker's own retry loop.
+		awsConf.WithMaxRetries(0)
 	}
 
-	input := &lambda.InvokeInput{
-		FunctionName: &funcName,
-		Payload:      payload,
+	sess, err := session.NewSession(awsConf)
+	if err != nil {
+		return err
 	}
-	if c.Qualifier != "" {
-		input.Qualifier = &c.Qualifier
+
+	var invoker Invoker
+	if c.AwsRoleArn == "" {
+		invoker = lambda.New(sess)
+	} else {
+		var roleCreds *credentials.Credentials
+		if c.AwsWebIdentityTokenFile != "" {
+			roleCreds = stscreds.NewWebIdentityCredentials(
+				sess, c.AwsRoleArn, c.AwsRoleSessionName, c.AwsWebIdentityTokenFile,
+			)
+		} else {
+			roleCreds = stscreds.NewCredentials(sess, c.AwsRoleArn, func(p *stscreds.AssumeRoleProvider) {
+				if c.AwsRoleSessionName != "" {
+					p.RoleSessionName = c.AwsRoleSessionName
+				}
+				if c.AwsExternalID != "" {
+					p.ExternalID = &c.AwsExternalID
+				}
+			})
+		}
+		invoker = lambda.New(sess, aws.NewConfig().WithCredentials(roleCreds))
 	}
-	return input, nil
+
+	if wrapResilient {
+		invoker = newResilientInvoker(invoker, c)
+	}
+	c.invoker = invoker
+	return nil
 }
 
-func (c *Config) initLambdaClient() error {
-	sess, err := session.NewSession(c.ToAwsConfig())
+// initResponseTemplate parses ResponseTemplate, if set, into responseTmpl.
+func (c *Config) initResponseTemplate() error {
+	if c.ResponseTemplate == "" {
+		return nil
+	}
+
+	tmpl, err := template.ParseFiles(c.ResponseTemplate)
 	if err != nil {
 		return err
 	}
-	c.invoker = lambda.New(sess)
+	c.responseTmpl = tmpl
 	return nil
 }
 
@@ -242,6 +539,18 @@ func ParseConfigs(c *caddy.Controller) ([]*Config, error) {
 			conf.AwsSecret = val
 		case "aws_region":
 			conf.AwsRegion = val
+		case "aws_profile":
+			conf.AwsProfile = val
+		case "aws_shared_credentials_file":
+			conf.AwsSharedCredentialsFile = val
+		case "aws_role_arn":
+			conf.AwsRoleArn = val
+		case "aws_role_session_name":
+			conf.AwsRoleSessionName = val
+		case "aws_external_id":
+			conf.AwsExternalID = val
+		case "aws_web_identity_token_file":
+			conf.AwsWebIdentityTokenFile = val
 		case "qualifier":
 			conf.Qualifier = val
 		case "name_prepend":
@@ -252,6 +561,43 @@ func ParseConfigs(c *caddy.Controller) ([]*Config, error) {
 			conf.Single = val
 		case "strip_path_prefix":
 			conf.StripPathPrefix = toBool(val)
+		case "payload_format":
+			conf.PayloadFormat = val
+		case "response_template":
+			conf.ResponseTemplate = val
+		case "content_type_default":
+			conf.ContentTypeDefault = val
+		case "invocation_type":
+			conf.InvocationType = val
+		case "async_functions":
+			conf.AsyncFunctions = append(conf.AsyncFunctions, val)
+			conf.AsyncFunctions = append(conf.AsyncFunctions, c.RemainingArgs()...)
+		case "invoke_timeout":
+			d, err := time.ParseDuration(val)
+			if err != nil {
+				return nil, err
+			}
+			conf.InvokeTimeout = d
+		case "max_retries":
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return nil, err
+			}
+			conf.MaxRetries = n
+		case "retry_backoff":
+			conf.RetryBackoff = val
+		case "breaker_failures":
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return nil, err
+			}
+			conf.BreakerFailures = n
+		case "breaker_cooldown":
+			d, err := time.ParseDuration(val)
+			if err != nil {
+				return nil, err
+			}
+			conf.BreakerCooldown = d
 		case "include":
 			conf.Include = append(conf.Include, val)
 			conf.Include = append(conf.Include, c.RemainingArgs()...)
@@ -264,14 +610,22 @@ func ParseConfigs(c *caddy.Controller) ([]*Config, error) {
 			}
 			value := strings.Join(c.RemainingArgs(), " ")
 			conf.UpstreamHeaders[val] = []string{value}
+		case "header_downstream":
+			if conf.DownstreamHeaders == nil {
+				conf.DownstreamHeaders = make(map[string][]string)
+			}
+			value := strings.Join(c.RemainingArgs(), " ")
+			conf.DownstreamHeaders[val] = []string{value}
 		default:
 			last = val
 		}
 	}
 
 	for _, conf := range configs {
-		err := conf.initLambdaClient()
-		if err != nil {
+		if err := conf.initLambdaClient(); err != nil {
+			return nil, err
+		}
+		if err := conf.initResponseTemplate(); err != nil {
 			return nil, err
 		}
 	}
@@ -289,6 +643,65 @@ func toBool(s string) bool {
 	return false
 }
 
+// funcNameRule is a single parsed Include/Exclude entry. A plain entry is
+// matched with matchGlob. An entry with a leading '~' is compiled as a
+// regexp.Regexp, optionally followed by a "-> replacement" clause that
+// rewrites the matched name via regexp.Regexp.ReplaceAllString (e.g. "$2")
+// before it is sent to Lambda.
+type funcNameRule struct {
+	isRegex bool
+	glob    string
+	re      *regexp.Regexp
+	rewrite string
+}
+
+// parseFuncNameRules parses a whole Include/Exclude list, in order.
+func parseFuncNameRules(rules []string) []*funcNameRule {
+	parsed := make([]*funcNameRule, len(rules))
+	for i, rule := range rules {
+		parsed[i] = parseFuncNameRule(rule)
+	}
+	return parsed
+}
+
+// parseFuncNameRule parses a single Include/Exclude entry. An invalid
+// regexp is treated as a rule that never matches, consistent with
+// ResolveFunction's "no attempt to validate" stance on malformed input.
+func parseFuncNameRule(rule string) *funcNameRule {
+	if !strings.HasPrefix(rule, "~") {
+		return &funcNameRule{glob: rule}
+	}
+
+	pattern := strings.TrimPrefix(rule, "~")
+	rewrite := ""
+	if idx := strings.Index(pattern, "->"); idx >= 0 {
+		rewrite = strings.TrimSpace(pattern[idx+2:])
+		pattern = strings.TrimSpace(pattern[:idx])
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return &funcNameRule{isRegex: true}
+	}
+	return &funcNameRule{isRegex: true, re: re, rewrite: rewrite}
+}
+
+// match reports whether name satisfies the rule, and the name to invoke
+// on Lambda: name unchanged, unless the rule is a regexp with a "->
+// replacement" clause, in which case the rewritten name is returned.
+func (f *funcNameRule) match(name string) (string, bool) {
+	if !f.isRegex {
+		return name, matchGlob(name, f.glob)
+	}
+	if f.re == nil || !f.re.MatchString(name) {
+		return name, false
+	}
+	if f.rewrite == "" {
+		return name, true
+	}
+	return f.re.ReplaceAllString(name, f.rewrite), true
+}
+
 // matchGlob returns true if string s matches the rule.
 // Simple prefix and suffix wildcards are supported with '*'.
 // For example, string 'hello' matches rules: 'hello', 'hel*', '*llo', '*ell*'
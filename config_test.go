@@ -3,9 +3,12 @@ package awslambda
 import (
 	"bytes"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
@@ -45,6 +48,44 @@ func TestAcceptsFunction(t *testing.T) {
 	}
 }
 
+func TestResolveFunctionRegexRewrite(t *testing.T) {
+	c := Config{
+		Include: []string{`~^api-(v\d+)-(.*)$ -> prod-$2`},
+	}
+
+	for i, test := range []struct {
+		name         string
+		expectedName string
+		expectedOk   bool
+	}{
+		{"api-v1-user", "prod-user", true},
+		{"api-v2-order", "prod-order", true},
+		{"other", "other", false},
+	} {
+		actual, ok := c.ResolveFunction(test.name)
+		if actual != test.expectedName || ok != test.expectedOk {
+			t.Errorf("\nTest %d - name: %s\nExpected: %s, %v\n  Actual: %s, %v",
+				i, test.name, test.expectedName, test.expectedOk, actual, ok)
+		}
+	}
+
+	// a regexp Include rule with no "-> replacement" clause just filters,
+	// same as a glob rule
+	c2 := Config{Include: []string{`~^api-\d+$`}}
+	if actual, ok := c2.ResolveFunction("api-123"); actual != "api-123" || !ok {
+		t.Errorf("Expected api-123, true; got: %s, %v", actual, ok)
+	}
+	if _, ok := c2.ResolveFunction("api-abc"); ok {
+		t.Errorf("Expected api-abc to be rejected")
+	}
+
+	// excluded names are never rewritten or invoked
+	c3 := Config{Exclude: []string{`~^api-(v\d+)-(.*)$ -> prod-$2`}}
+	if _, ok := c3.ResolveFunction("api-v1-user"); ok {
+		t.Errorf("Expected api-v1-user to be excluded")
+	}
+}
+
 func TestMatchGlob(t *testing.T) {
 	for i, test := range []struct {
 		candidate string
@@ -90,6 +131,18 @@ func TestToAwsConfigStaticRegion(t *testing.T) {
 	}
 }
 
+func TestToAwsConfigSharedCreds(t *testing.T) {
+	c := &Config{
+		AwsProfile:               "my-profile",
+		AwsSharedCredentialsFile: "/home/user/.aws/credentials",
+	}
+	expected := credentials.NewSharedCredentials("/home/user/.aws/credentials", "my-profile")
+	actual := c.ToAwsConfig()
+	if !reflect.DeepEqual(expected, actual.Credentials) {
+		t.Errorf("\nExpected: %v\n  Actual: %v", expected, actual.Credentials)
+	}
+}
+
 func TestToAwsConfigDefaults(t *testing.T) {
 	c := &Config{}
 	expected := aws.NewConfig()
@@ -137,6 +190,48 @@ func TestParseConfigs(t *testing.T) {
 				},
 			},
 		},
+		{`awslambda /cross-account/ {
+    aws_profile                   ops
+    aws_shared_credentials_file   /etc/aws/credentials
+    aws_role_arn                  arn:aws:iam::123456789012:role/lambda-invoker
+    aws_role_session_name         caddy-awslambda
+    aws_external_id               my-external-id
+    aws_web_identity_token_file   /var/run/secrets/token
+}`,
+			[]*Config{
+				&Config{
+					Path:                     "/cross-account/",
+					AwsProfile:               "ops",
+					AwsSharedCredentialsFile: "/etc/aws/credentials",
+					AwsRoleArn:               "arn:aws:iam::123456789012:role/lambda-invoker",
+					AwsRoleSessionName:       "caddy-awslambda",
+					AwsExternalID:            "my-external-id",
+					AwsWebIdentityTokenFile:  "/var/run/secrets/token",
+					Include:                  []string{},
+					Exclude:                  []string{},
+				},
+			},
+		},
+		{`awslambda /resilient/ {
+    invoke_timeout     5s
+    max_retries        3
+    retry_backoff      exponential
+    breaker_failures   5
+    breaker_cooldown   30s
+}`,
+			[]*Config{
+				&Config{
+					Path:            "/resilient/",
+					InvokeTimeout:   5 * time.Second,
+					MaxRetries:      3,
+					RetryBackoff:    "exponential",
+					BreakerFailures: 5,
+					BreakerCooldown: 30 * time.Second,
+					Include:         []string{},
+					Exclude:         []string{},
+				},
+			},
+		},
 		{`awslambda /first/ {
     aws_region us-west-2
     qualifier  dev
@@ -220,10 +315,12 @@ func TestMaybeToInvokeInput(t *testing.T) {
 	if err != nil {
 		t.Fatalf("NewRequest returned err: %v", err)
 	}
+	requestResponse := lambda.InvocationTypeRequestResponse
 	expected := lambda.InvokeInput{
-		FunctionName: &funcName,
-		Qualifier:    &c.Qualifier,
-		Payload:      marshalJSON(req),
+		FunctionName:   &funcName,
+		Qualifier:      &c.Qualifier,
+		Payload:        marshalJSON(req),
+		InvocationType: &requestResponse,
 	}
 	eqOrErr(expected, *input, 0, t)
 
@@ -235,6 +332,152 @@ func TestMaybeToInvokeInput(t *testing.T) {
 	}
 }
 
+func TestMaybeToInvokeInputRegexRewrite(t *testing.T) {
+	r1 := mustNewRequest("PUT", "/api/api-v2-user", bytes.NewBufferString("hello world"))
+	r2 := mustNewRequest("PUT", "/api/api-v2-user", bytes.NewBufferString("hello world"))
+
+	c := Config{
+		Path:    "/api/",
+		Include: []string{`~^api-(v\d+)-(.*)$ -> prod-$2`},
+	}
+	input, err := c.MaybeToInvokeInput(r1)
+	if err != nil {
+		t.Fatalf("MaybeToInvokeInput returned err: %v", err)
+	}
+	if input == nil {
+		t.Fatalf("MaybeToInvokeInput returned nil input")
+	}
+
+	funcName := "prod-user"
+	req, err := NewRequest(r2)
+	if err != nil {
+		t.Fatalf("NewRequest returned err: %v", err)
+	}
+	requestResponse := lambda.InvocationTypeRequestResponse
+	expected := lambda.InvokeInput{
+		FunctionName:   &funcName,
+		Payload:        marshalJSON(req),
+		InvocationType: &requestResponse,
+	}
+	eqOrErr(expected, *input, 0, t)
+}
+
+func TestMaybeToInvokeInputAPIGateway(t *testing.T) {
+	r1 := mustNewRequest("PUT", "/api/user?a=b", bytes.NewBufferString("hello world"))
+	r2 := mustNewRequest("PUT", "/api/user?a=b", bytes.NewBufferString("hello world"))
+
+	c := Config{
+		Path:          "/api/",
+		PayloadFormat: PayloadFormatAPIGateway,
+	}
+	input, err := c.MaybeToInvokeInput(r1)
+	if err != nil {
+		t.Fatalf("MaybeToInvokeInput returned err: %v", err)
+	}
+	if input == nil {
+		t.Fatalf("MaybeToInvokeInput returned nil input")
+	}
+
+	funcName := "user"
+	req, err := NewAPIGatewayRequest(r2)
+	if err != nil {
+		t.Fatalf("NewAPIGatewayRequest returned err: %v", err)
+	}
+	requestResponse := lambda.InvocationTypeRequestResponse
+	expected := lambda.InvokeInput{
+		FunctionName:   &funcName,
+		Payload:        marshalJSON(req),
+		InvocationType: &requestResponse,
+	}
+	eqOrErr(expected, *input, 0, t)
+}
+
+func TestMaybeToInvokeInputAPIGatewayV2(t *testing.T) {
+	r1 := mustNewRequest("PUT", "/api/user?a=b", bytes.NewBufferString("hello world"))
+	r2 := mustNewRequest("PUT", "/api/user?a=b", bytes.NewBufferString("hello world"))
+
+	c := Config{
+		Path:          "/api/",
+		PayloadFormat: PayloadFormatAPIGatewayV2,
+	}
+	input, err := c.MaybeToInvokeInput(r1)
+	if err != nil {
+		t.Fatalf("MaybeToInvokeInput returned err: %v", err)
+	}
+	if input == nil {
+		t.Fatalf("MaybeToInvokeInput returned nil input")
+	}
+
+	funcName := "user"
+	req, err := NewAPIGatewayV2Request(r2)
+	if err != nil {
+		t.Fatalf("NewAPIGatewayV2Request returned err: %v", err)
+	}
+	requestResponse := lambda.InvocationTypeRequestResponse
+	expected := lambda.InvokeInput{
+		FunctionName:   &funcName,
+		Payload:        marshalJSON(req),
+		InvocationType: &requestResponse,
+	}
+	eqOrErr(expected, *input, 0, t)
+}
+
+func TestResolveInvocationType(t *testing.T) {
+	c := Config{
+		InvocationType: lambda.InvocationTypeDryRun,
+		AsyncFunctions: []string{"worker-*", "emailer"},
+	}
+
+	for i, test := range []struct {
+		funcName string
+		expected string
+	}{
+		{"worker-send", lambda.InvocationTypeEvent},
+		{"emailer", lambda.InvocationTypeEvent},
+		{"hello", lambda.InvocationTypeDryRun},
+	} {
+		actual := c.resolveInvocationType(test.funcName)
+		if actual != test.expected {
+			t.Errorf("\nTest %d - funcName: %s\nExpected: %s\n  Actual: %s",
+				i, test.funcName, test.expected, actual)
+		}
+	}
+
+	c2 := Config{}
+	if actual := c2.resolveInvocationType("anything"); actual != lambda.InvocationTypeRequestResponse {
+		t.Errorf("Expected default InvocationType of %s, got: %s", lambda.InvocationTypeRequestResponse, actual)
+	}
+}
+
+func TestInitResponseTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "awslambda-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	tmplPath := filepath.Join(dir, "response.tmpl")
+	err = ioutil.WriteFile(tmplPath, []byte(`{"status": {{.StatusCode}}}`), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write template file: %v", err)
+	}
+
+	c := &Config{ResponseTemplate: tmplPath}
+	if err := c.initResponseTemplate(); err != nil {
+		t.Fatalf("initResponseTemplate returned err: %v", err)
+	}
+	if c.responseTmpl == nil {
+		t.Fatalf("Expected responseTmpl to be set")
+	}
+
+	c2 := &Config{}
+	if err := c2.initResponseTemplate(); err != nil {
+		t.Fatalf("initResponseTemplate returned err: %v", err)
+	}
+	if c2.responseTmpl != nil {
+		t.Errorf("Expected responseTmpl to remain nil when ResponseTemplate is unset")
+	}
+}
+
 func TestSingleFunction(t *testing.T) {
 	r1 := mustNewRequest("PUT", "/api/user", bytes.NewBufferString("hello world"))
 
@@ -0,0 +1,401 @@
+package awslambda
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+)
+
+// Invoker is the subset of *lambda.Lambda used by Handler, allowing tests
+// to substitute a fake implementation.
+type Invoker interface {
+	Invoke(input *lambda.InvokeInput) (*lambda.InvokeOutput, error)
+}
+
+// Payload format identifiers accepted by the Config.PayloadFormat directive.
+const (
+	// PayloadFormatDefault is the plugin's native event shape: a
+	// RequestMeta/Request envelope around the raw request body.
+	PayloadFormatDefault = "default"
+	// PayloadFormatAPIGateway marshals the event Lambda would receive from
+	// an API Gateway REST API using the Lambda Proxy Integration.
+	PayloadFormatAPIGateway = "apigateway"
+	// PayloadFormatAPIGatewayV2 marshals the event Lambda would receive
+	// from an API Gateway HTTP API (payload format version 2.0).
+	PayloadFormatAPIGatewayV2 = "apigatewayv2"
+)
+
+// RequestMeta carries the HTTP request metadata sent alongside the body
+// in the default payload format.
+type RequestMeta struct {
+	Method  string              `json:"method"`
+	Path    string              `json:"path"`
+	Headers map[string][]string `json:"headers"`
+	Query   map[string][]string `json:"query"`
+}
+
+// Request is the JSON document sent as the Lambda Invoke Payload when
+// Config.PayloadFormat is empty or "default".
+type Request struct {
+	Meta *RequestMeta `json:"meta"`
+	Body string       `json:"body"`
+}
+
+// NewRequest reads r.Body and returns a Request describing r in the
+// plugin's native (non API Gateway) payload format.
+func NewRequest(r *http.Request) (*Request, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := map[string][]string{}
+	for k, v := range r.Header {
+		headers[strings.ToLower(k)] = v
+	}
+
+	return &Request{
+		Meta: &RequestMeta{
+			Method:  r.Method,
+			Path:    r.URL.Path,
+			Headers: headers,
+			Query:   map[string][]string(r.URL.Query()),
+		},
+		Body: string(body),
+	}, nil
+}
+
+// APIGatewayRequest is the event shape Lambda receives from an API
+// Gateway REST API configured with the Lambda Proxy Integration. See:
+// https://docs.aws.amazon.com/apigateway/latest/developerguide/set-up-lambda-proxy-integrations.html
+type APIGatewayRequest struct {
+	HTTPMethod            string              `json:"httpMethod"`
+	Path                  string              `json:"path"`
+	PathParameters        map[string]string   `json:"pathParameters"`
+	QueryStringParameters map[string]string   `json:"queryStringParameters"`
+	Headers               map[string]string   `json:"headers"`
+	MultiValueHeaders     map[string][]string `json:"multiValueHeaders"`
+	Body                  string              `json:"body"`
+	IsBase64Encoded       bool                `json:"isBase64Encoded"`
+}
+
+// NewAPIGatewayRequest reads r.Body and returns an APIGatewayRequest so
+// that existing Lambda functions written against the API Gateway Proxy
+// Integration event shape can be invoked unmodified. There is no concept
+// of a resource path template in this plugin, so PathParameters is always
+// empty.
+func NewAPIGatewayRequest(r *http.Request) (*APIGatewayRequest, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := map[string]string{}
+	multiValueHeaders := map[string][]string{}
+	for k, v := range r.Header {
+		k = strings.ToLower(k)
+		multiValueHeaders[k] = v
+		headers[k] = v[len(v)-1]
+	}
+
+	query := map[string]string{}
+	for k, v := range r.URL.Query() {
+		query[k] = v[len(v)-1]
+	}
+
+	encodedBody, isBase64 := encodeBody(body)
+
+	return &APIGatewayRequest{
+		HTTPMethod:            r.Method,
+		Path:                  r.URL.Path,
+		PathParameters:        map[string]string{},
+		QueryStringParameters: query,
+		Headers:               headers,
+		MultiValueHeaders:     multiValueHeaders,
+		Body:                  encodedBody,
+		IsBase64Encoded:       isBase64,
+	}, nil
+}
+
+// APIGatewayV2Request is the event shape Lambda receives from an API
+// Gateway HTTP API using payload format version 2.0.
+type APIGatewayV2Request struct {
+	Version               string                     `json:"version"`
+	RawPath               string                     `json:"rawPath"`
+	RawQueryString        string                     `json:"rawQueryString"`
+	Headers               map[string]string          `json:"headers"`
+	QueryStringParameters map[string]string          `json:"queryStringParameters"`
+	RequestContext        APIGatewayV2RequestContext `json:"requestContext"`
+	Body                  string                     `json:"body"`
+	IsBase64Encoded       bool                       `json:"isBase64Encoded"`
+}
+
+// APIGatewayV2RequestContext carries the subset of the HTTP API
+// requestContext that NewAPIGatewayV2Request populates.
+type APIGatewayV2RequestContext struct {
+	HTTP APIGatewayV2HTTP `json:"http"`
+}
+
+// APIGatewayV2HTTP mirrors requestContext.http in the HTTP API event.
+type APIGatewayV2HTTP struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+}
+
+// NewAPIGatewayV2Request reads r.Body and returns an APIGatewayV2Request
+// in the HTTP API (payload format version 2.0) shape.
+func NewAPIGatewayV2Request(r *http.Request) (*APIGatewayV2Request, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := map[string]string{}
+	for k, v := range r.Header {
+		headers[strings.ToLower(k)] = strings.Join(v, ",")
+	}
+
+	query := map[string]string{}
+	for k, v := range r.URL.Query() {
+		query[k] = strings.Join(v, ",")
+	}
+
+	encodedBody, isBase64 := encodeBody(body)
+
+	return &APIGatewayV2Request{
+		Version:               "2.0",
+		RawPath:               r.URL.Path,
+		RawQueryString:        r.URL.RawQuery,
+		Headers:               headers,
+		QueryStringParameters: query,
+		RequestContext: APIGatewayV2RequestContext{
+			HTTP: APIGatewayV2HTTP{
+				Method: r.Method,
+				Path:   r.URL.Path,
+			},
+		},
+		Body:            encodedBody,
+		IsBase64Encoded: isBase64,
+	}, nil
+}
+
+// APIGatewayResponse is the reply shape a Lambda function returns under
+// the API Gateway Proxy Integration (v1 and v2 both accept this shape).
+type APIGatewayResponse struct {
+	StatusCode        int                 `json:"statusCode"`
+	Headers           map[string]string   `json:"headers"`
+	MultiValueHeaders map[string][]string `json:"multiValueHeaders"`
+	Body              string              `json:"body"`
+	IsBase64Encoded   bool                `json:"isBase64Encoded"`
+}
+
+// encodeBody base64-encodes body when it is not valid UTF-8 text,
+// matching how API Gateway decides whether to set isBase64Encoded on the
+// event it hands to Lambda.
+func encodeBody(body []byte) (string, bool) {
+	if utf8.Valid(body) {
+		return string(body), false
+	}
+	return base64.StdEncoding.EncodeToString(body), true
+}
+
+// Handler implements httpserver.Handler, proxying requests matching one
+// of its Configs to the corresponding AWS Lambda function.
+type Handler struct {
+	Next    httpserver.Handler
+	Configs []*Config
+}
+
+// ServeHTTP satisfies the httpserver.Handler interface.
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, error) {
+	for _, c := range h.Configs {
+		if !strings.HasPrefix(r.URL.Path, c.Path) {
+			continue
+		}
+
+		input, err := c.MaybeToInvokeInput(r)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if input == nil {
+			continue
+		}
+
+		if input.InvocationType != nil && *input.InvocationType == lambda.InvocationTypeEvent {
+			log.Printf("awslambda: invoking %s asynchronously (correlation-id=%s)",
+				*input.FunctionName, newCorrelationID())
+			if _, err := c.invoker.Invoke(input); err != nil {
+				if isBreakerOpenError(err) {
+					return http.StatusServiceUnavailable, err
+				}
+				return http.StatusInternalServerError, err
+			}
+			return http.StatusAccepted, nil
+		}
+
+		out, err := c.invoker.Invoke(input)
+		if err != nil {
+			if isBreakerOpenError(err) {
+				return http.StatusServiceUnavailable, err
+			}
+			if input.InvocationType != nil && *input.InvocationType == lambda.InvocationTypeDryRun {
+				return http.StatusBadRequest, err
+			}
+			return http.StatusInternalServerError, err
+		}
+
+		return c.WriteInvokeOutput(w, r, input, out)
+	}
+
+	return h.Next.ServeHTTP(w, r)
+}
+
+// newCorrelationID returns a short random identifier logged alongside
+// asynchronous (Event) invocations, next to the function name, so each
+// such call can be told apart in the logs. The id is only logged; it is not
+// sent to Lambda or returned to the client.
+func newCorrelationID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// WriteInvokeOutput writes a Lambda InvokeOutput to w according to the
+// Config's PayloadFormat. The default format writes the raw reply
+// payload with a 200 status; the apigateway formats decode the
+// {statusCode, headers, body, isBase64Encoded} reply shape and apply it
+// to w. In both cases, ResponseTemplate, DownstreamHeaders and
+// ContentTypeDefault are then applied before the body is written.
+//
+// A successful DryRun invocation carries no Payload to decode (Lambda
+// only validates the request and permissions), so it is written as a
+// bare 204 before any PayloadFormat-specific decoding is attempted.
+func (c *Config) WriteInvokeOutput(w http.ResponseWriter, r *http.Request, input *lambda.InvokeInput, out *lambda.InvokeOutput) (int, error) {
+	if input.InvocationType != nil && *input.InvocationType == lambda.InvocationTypeDryRun {
+		w.WriteHeader(http.StatusNoContent)
+		return http.StatusNoContent, nil
+	}
+
+	var body []byte
+	status := http.StatusOK
+
+	switch c.PayloadFormat {
+	case PayloadFormatAPIGateway, PayloadFormatAPIGatewayV2:
+		var err error
+		body, status, err = c.decodeAPIGatewayResponse(w.Header(), out.Payload)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+	default:
+		body = out.Payload
+	}
+
+	body, err := c.renderResponseTemplate(body, status, *input.FunctionName)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	c.applyDownstreamHeaders(r, w.Header(), status, *input.FunctionName)
+
+	if c.ContentTypeDefault != "" && w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", c.ContentTypeDefault)
+	}
+
+	w.WriteHeader(status)
+	w.Write(body)
+
+	return status, nil
+}
+
+// decodeAPIGatewayResponse unmarshals an APIGatewayResponse payload,
+// applying its headers to header and base64-decoding its body when
+// IsBase64Encoded is set. It returns the decoded body and status code.
+func (c *Config) decodeAPIGatewayResponse(header http.Header, payload []byte) ([]byte, int, error) {
+	var resp APIGatewayResponse
+	if err := json.Unmarshal(payload, &resp); err != nil {
+		return nil, 0, err
+	}
+
+	body := []byte(resp.Body)
+	if resp.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(resp.Body)
+		if err != nil {
+			return nil, 0, err
+		}
+		body = decoded
+	}
+
+	for k, v := range resp.Headers {
+		header.Set(k, v)
+	}
+	for k, v := range resp.MultiValueHeaders {
+		header.Del(k)
+		for _, vv := range v {
+			header.Add(k, vv)
+		}
+	}
+
+	status := resp.StatusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return body, status, nil
+}
+
+// responseTemplateData is the data model passed to ResponseTemplate.
+type responseTemplateData struct {
+	Body         string
+	StatusCode   int
+	FunctionName string
+}
+
+// renderResponseTemplate applies c.ResponseTemplate (if set) to body,
+// returning body unmodified otherwise.
+func (c *Config) renderResponseTemplate(body []byte, status int, funcName string) ([]byte, error) {
+	if c.responseTmpl == nil {
+		return body, nil
+	}
+
+	var buf bytes.Buffer
+	data := responseTemplateData{
+		Body:         string(body),
+		StatusCode:   status,
+		FunctionName: funcName,
+	}
+	if err := c.responseTmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// applyDownstreamHeaders applies the header_downstream directive to
+// header, using caddy's placeholder replacer. In addition to the usual
+// request placeholders, {lambda_status} and {lambda_function} are made
+// available.
+func (c *Config) applyDownstreamHeaders(r *http.Request, header http.Header, status int, funcName string) {
+	if len(c.DownstreamHeaders) == 0 {
+		return
+	}
+
+	replInt := r.Context().Value(httpserver.ReplacerCtxKey)
+	replacer := replInt.(httpserver.Replacer)
+	replacer.Set("lambda_status", strconv.Itoa(status))
+	replacer.Set("lambda_function", funcName)
+
+	for k, v := range c.DownstreamHeaders {
+		header[http.CanonicalHeaderKey(k)] = c.replacedHeaderValues(r, v)
+	}
+}
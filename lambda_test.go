@@ -2,12 +2,18 @@ package awslambda
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+	"text/template"
 
 	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/mholt/caddy/caddyhttp/httpserver"
 )
 
 func TestInvokeOK(t *testing.T) {
@@ -62,8 +68,248 @@ func TestInvokeInvalidFunc(t *testing.T) {
 	}
 }
 
+func TestInvokeOKAPIGateway(t *testing.T) {
+	replyPayload := marshalJSON(APIGatewayResponse{
+		StatusCode: 201,
+		Headers:    map[string]string{"x-custom": "yes"},
+		Body:       "hello",
+	})
+	invoker := &FakeInvoker{
+		Calls: []*lambda.InvokeInput{},
+		Reply: &lambda.InvokeOutput{
+			Payload: replyPayload,
+		},
+	}
+	h := initHandler(invoker)
+	h.Configs[0].PayloadFormat = PayloadFormatAPIGateway
+	r, err := http.NewRequest("POST", "/lambda-test/foo", bytes.NewBufferString("hi"))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	w := httptest.NewRecorder()
+
+	status, err := h.ServeHTTP(w, r)
+	if err != nil {
+		t.Errorf("ServeHTTP returned err: %v", err)
+	}
+	if status != 201 {
+		t.Errorf("Expected 201 status, got: %d", status)
+	}
+	if w.Code != 201 {
+		t.Errorf("Expected 201 recorder code, got: %d", w.Code)
+	}
+	if actual := w.Header().Get("x-custom"); actual != "yes" {
+		t.Errorf("Expected x-custom header to be set, got: %s", actual)
+	}
+	if actual := w.Body.String(); actual != "hello" {
+		t.Errorf("\nResponse body did not match\nExpected: hello\n  Actual: %s", actual)
+	}
+}
+
+func TestInvokeOKAPIGatewayV2(t *testing.T) {
+	replyPayload := marshalJSON(APIGatewayResponse{
+		StatusCode: 201,
+		Headers:    map[string]string{"x-custom": "yes"},
+		Body:       "hello",
+	})
+	invoker := &FakeInvoker{
+		Calls: []*lambda.InvokeInput{},
+		Reply: &lambda.InvokeOutput{
+			Payload: replyPayload,
+		},
+	}
+	h := initHandler(invoker)
+	h.Configs[0].PayloadFormat = PayloadFormatAPIGatewayV2
+	r, err := http.NewRequest("POST", "/lambda-test/foo", bytes.NewBufferString("hi"))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	w := httptest.NewRecorder()
+
+	status, err := h.ServeHTTP(w, r)
+	if err != nil {
+		t.Errorf("ServeHTTP returned err: %v", err)
+	}
+	if status != 201 {
+		t.Errorf("Expected 201 status, got: %d", status)
+	}
+	if w.Code != 201 {
+		t.Errorf("Expected 201 recorder code, got: %d", w.Code)
+	}
+	if actual := w.Header().Get("x-custom"); actual != "yes" {
+		t.Errorf("Expected x-custom header to be set, got: %s", actual)
+	}
+	if actual := w.Body.String(); actual != "hello" {
+		t.Errorf("\nResponse body did not match\nExpected: hello\n  Actual: %s", actual)
+	}
+}
+
+func TestInvokeEvent(t *testing.T) {
+	invoker := &FakeInvoker{
+		Calls: []*lambda.InvokeInput{},
+		Reply: &lambda.InvokeOutput{},
+	}
+	h := initHandler(invoker)
+	h.Configs[0].InvocationType = lambda.InvocationTypeEvent
+	r, err := http.NewRequest("POST", "/lambda-test/foo", bytes.NewBufferString("hi"))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	w := httptest.NewRecorder()
+
+	status, err := h.ServeHTTP(w, r)
+	if err != nil {
+		t.Errorf("ServeHTTP returned err: %v", err)
+	}
+	if status != http.StatusAccepted {
+		t.Errorf("Expected 202 status, got: %d", status)
+	}
+	if len(invoker.Calls) != 1 {
+		t.Errorf("Expected 1 Invoke call, but got: %+v", invoker.Calls)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("Expected empty response body, got: %s", w.Body.String())
+	}
+}
+
+func TestInvokeDryRunError(t *testing.T) {
+	invoker := &FakeInvoker{
+		Calls:      []*lambda.InvokeInput{},
+		ReplyError: errors.New("InvalidParameterValueException: bad input"),
+	}
+	h := initHandler(invoker)
+	h.Configs[0].InvocationType = lambda.InvocationTypeDryRun
+	r, err := http.NewRequest("POST", "/lambda-test/foo", bytes.NewBufferString("hi"))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	w := httptest.NewRecorder()
+
+	status, err := h.ServeHTTP(w, r)
+	if err == nil {
+		t.Errorf("Expected ServeHTTP to return err")
+	}
+	if status != http.StatusBadRequest {
+		t.Errorf("Expected 400 status, got: %d", status)
+	}
+}
+
+func TestInvokeDryRunOKAPIGateway(t *testing.T) {
+	invoker := &FakeInvoker{
+		Calls: []*lambda.InvokeInput{},
+		Reply: &lambda.InvokeOutput{}, // DryRun never returns a Payload
+	}
+	h := initHandler(invoker)
+	h.Configs[0].InvocationType = lambda.InvocationTypeDryRun
+	h.Configs[0].PayloadFormat = PayloadFormatAPIGateway
+	r, err := http.NewRequest("POST", "/lambda-test/foo", bytes.NewBufferString("hi"))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	w := httptest.NewRecorder()
+
+	status, err := h.ServeHTTP(w, r)
+	if err != nil {
+		t.Errorf("ServeHTTP returned err: %v", err)
+	}
+	if status != http.StatusNoContent {
+		t.Errorf("Expected 204 status, got: %d", status)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("Expected empty response body, got: %s", w.Body.String())
+	}
+}
+
+func TestWriteInvokeOutputDownstreamHeaders(t *testing.T) {
+	invoker := &FakeInvoker{
+		Calls: []*lambda.InvokeInput{},
+		Reply: &lambda.InvokeOutput{
+			Payload: []byte("hello"),
+		},
+	}
+	h := initHandler(invoker)
+	h.Configs[0].DownstreamHeaders = map[string][]string{
+		"x-function": {"{lambda_function}"},
+		"x-status":   {"{lambda_status}"},
+	}
+	h.Configs[0].ContentTypeDefault = "text/plain"
+
+	r, err := http.NewRequest("POST", "/lambda-test/foo", bytes.NewBufferString("hi"))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	r = r.WithContext(context.WithValue(r.Context(), httpserver.ReplacerCtxKey, &FakeReplacer{}))
+	w := httptest.NewRecorder()
+
+	status, err := h.ServeHTTP(w, r)
+	if err != nil {
+		t.Errorf("ServeHTTP returned err: %v", err)
+	}
+	if status != 200 {
+		t.Errorf("Expected 200 status, got: %d", status)
+	}
+	if actual := w.Header().Get("x-function"); actual != "foo" {
+		t.Errorf("Expected x-function header of foo, got: %s", actual)
+	}
+	if actual := w.Header().Get("x-status"); actual != "200" {
+		t.Errorf("Expected x-status header of 200, got: %s", actual)
+	}
+	if actual := w.Header().Get("Content-Type"); actual != "text/plain" {
+		t.Errorf("Expected Content-Type of text/plain, got: %s", actual)
+	}
+	if actual := w.Body.String(); actual != "hello" {
+		t.Errorf("\nResponse body did not match\nExpected: hello\n  Actual: %s", actual)
+	}
+}
+
+func TestRenderResponseTemplate(t *testing.T) {
+	tmpl, err := template.New("resp").Parse(`{"fn": "{{.FunctionName}}", "status": {{.StatusCode}}, "body": {{.Body}}}`)
+	if err != nil {
+		t.Fatalf("Failed to parse template: %v", err)
+	}
+	c := &Config{responseTmpl: tmpl}
+
+	actual, err := c.renderResponseTemplate([]byte(`"hi"`), 200, "foo")
+	if err != nil {
+		t.Fatalf("renderResponseTemplate returned err: %v", err)
+	}
+	expected := `{"fn": "foo", "status": 200, "body": "hi"}`
+	if string(actual) != expected {
+		t.Errorf("\nExpected: %s\n  Actual: %s", expected, actual)
+	}
+}
+
 ////////////////////////////////////////
 
+// FakeReplacer is a minimal httpserver.Replacer that substitutes
+// {placeholder} tokens set via Set, used in place of caddy's real
+// replacer in tests.
+type FakeReplacer struct {
+	values map[string]string
+}
+
+func (r *FakeReplacer) Replace(s string) string {
+	for k, v := range r.values {
+		s = strings.Replace(s, "{"+k+"}", v, -1)
+	}
+	return s
+}
+
+func (r *FakeReplacer) Set(key, value string) {
+	if r.values == nil {
+		r.values = map[string]string{}
+	}
+	r.values[key] = value
+}
+
+// eqOrErr is a small reflect.DeepEqual-based assertion shared by this
+// file and config_test.go.
+func eqOrErr(expected, actual interface{}, idx int, t *testing.T) {
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("\nTest %d\nExpected: %+v\n  Actual: %+v", idx, expected, actual)
+	}
+}
+
 func marshalJSON(i interface{}) []byte {
 	j, err := json.Marshal(i)
 	if err != nil {
@@ -0,0 +1,262 @@
+package awslambda
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// Backoff strategies accepted by the retry_backoff directive.
+const (
+	RetryBackoffConstant    = "constant"
+	RetryBackoffExponential = "exponential"
+	RetryBackoffJittered    = "jittered"
+)
+
+// retryBackoffBase is the delay used for the first retry attempt; later
+// attempts scale it according to the configured strategy.
+const retryBackoffBase = 100 * time.Millisecond
+
+// breakerWindow is the sliding window over which BreakerFailures are
+// counted.
+const breakerWindow = time.Minute
+
+// breakerIdleTTL bounds how long a function's circuit breaker state is
+// kept once nothing has invoked it. Without this, traffic that resolves to
+// many distinct, rarely-repeated function names (typos, probing, a
+// permissive Include rule) would grow resilientInvoker.breakers forever.
+const breakerIdleTTL = 10 * time.Minute
+
+// breakerSweepThreshold is the number of tracked functions at which
+// breakerFor starts looking for idle entries to evict, so configs with a
+// handful of functions never pay the sweep cost.
+const breakerSweepThreshold = 1000
+
+// breakerOpenError is returned by resilientInvoker.Invoke when a
+// function's circuit breaker is open, short-circuiting the call without
+// contacting Lambda.
+type breakerOpenError struct {
+	funcName string
+}
+
+func (e *breakerOpenError) Error() string {
+	return fmt.Sprintf("awslambda: circuit breaker open for function %s", e.funcName)
+}
+
+// isBreakerOpenError reports whether err was returned because a circuit
+// breaker was open, as opposed to an error from the wrapped Invoker.
+func isBreakerOpenError(err error) bool {
+	_, ok := err.(*breakerOpenError)
+	return ok
+}
+
+// resilientInvoker wraps an Invoker with the retry/backoff and circuit
+// breaker behavior configured by a Config's max_retries, retry_backoff
+// and breaker_* directives. Circuit breaker state is tracked per resolved
+// function name, since a single Config may front many Lambda functions
+// with independent health.
+type resilientInvoker struct {
+	inner  Invoker
+	config *Config
+
+	mu       sync.Mutex
+	breakers map[string]*funcBreaker
+}
+
+// newResilientInvoker wraps inner with the retry and circuit breaker
+// behavior described by c.
+func newResilientInvoker(inner Invoker, c *Config) *resilientInvoker {
+	return &resilientInvoker{
+		inner:    inner,
+		config:   c,
+		breakers: make(map[string]*funcBreaker),
+	}
+}
+
+// Invoke satisfies the Invoker interface.
+func (inv *resilientInvoker) Invoke(input *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+	funcName := ""
+	if input.FunctionName != nil {
+		funcName = *input.FunctionName
+	}
+
+	var breaker *funcBreaker
+	if inv.config.BreakerFailures > 0 {
+		now := time.Now()
+		breaker = inv.breakerFor(funcName, now)
+
+		inv.mu.Lock()
+		allowed := breaker.allow(now)
+		inv.mu.Unlock()
+		if !allowed {
+			return nil, &breakerOpenError{funcName: funcName}
+		}
+	}
+
+	out, err := inv.invokeWithRetry(input)
+
+	if breaker != nil {
+		inv.mu.Lock()
+		if err != nil {
+			breaker.recordFailure(time.Now(), inv.config.BreakerFailures, inv.config.BreakerCooldown)
+		} else {
+			breaker.recordSuccess()
+		}
+		inv.mu.Unlock()
+	}
+
+	return out, err
+}
+
+// invokeWithRetry calls inner.Invoke, retrying throttling and transient
+// 5xx errors up to config.MaxRetries times with backoff. Errors
+// indicating the request itself is invalid fail fast without retrying.
+func (inv *resilientInvoker) invokeWithRetry(input *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+	var out *lambda.InvokeOutput
+	var err error
+
+	for attempt := 0; ; attempt++ {
+		out, err = inv.inner.Invoke(input)
+		if err == nil || isFailFastError(err) || attempt >= inv.config.MaxRetries || !isRetryableError(err) {
+			return out, err
+		}
+		time.Sleep(backoffDelay(inv.config.RetryBackoff, attempt))
+	}
+}
+
+// breakerFor returns the funcBreaker tracking funcName, creating one if
+// this is the first call seen for it, and records now as its last use so
+// evictIdleBreakers can later reclaim it.
+func (inv *resilientInvoker) breakerFor(funcName string, now time.Time) *funcBreaker {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	if len(inv.breakers) >= breakerSweepThreshold {
+		inv.evictIdleBreakers(now)
+	}
+
+	b, ok := inv.breakers[funcName]
+	if !ok {
+		b = &funcBreaker{}
+		inv.breakers[funcName] = b
+	}
+	b.lastUsed = now
+	return b
+}
+
+// evictIdleBreakers drops tracked breakers that are closed and haven't
+// been used in over breakerIdleTTL. Callers must hold inv.mu.
+func (inv *resilientInvoker) evictIdleBreakers(now time.Time) {
+	for funcName, b := range inv.breakers {
+		if b.openUntil.IsZero() && !b.probing && now.Sub(b.lastUsed) > breakerIdleTTL {
+			delete(inv.breakers, funcName)
+		}
+	}
+}
+
+// funcBreaker is the circuit breaker state for a single function name.
+// Callers must hold resilientInvoker.mu while touching it.
+type funcBreaker struct {
+	failures  []time.Time
+	openUntil time.Time
+	probing   bool
+	lastUsed  time.Time
+}
+
+// allow reports whether a call may proceed. Once the breaker is open, the
+// first call to observe openUntil has passed reserves the single
+// half-open probe slot and is let through; every other concurrent or
+// subsequent call is short-circuited until that probe's outcome is
+// recorded via recordSuccess/recordFailure.
+func (b *funcBreaker) allow(now time.Time) bool {
+	if b.openUntil.IsZero() {
+		return true
+	}
+	if now.Before(b.openUntil) {
+		return false
+	}
+	if b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// recordSuccess closes the breaker and clears its failure history.
+func (b *funcBreaker) recordSuccess() {
+	b.failures = nil
+	b.openUntil = time.Time{}
+	b.probing = false
+}
+
+// recordFailure appends a failure at now, drops failures outside
+// breakerWindow, and opens the breaker for cooldown if threshold is met.
+// A failed half-open probe re-opens the breaker immediately.
+func (b *funcBreaker) recordFailure(now time.Time, threshold int, cooldown time.Duration) {
+	if b.probing {
+		b.probing = false
+		b.openUntil = now.Add(cooldown)
+		return
+	}
+
+	b.failures = append(b.failures, now)
+	cutoff := now.Add(-breakerWindow)
+	kept := b.failures[:0]
+	for _, f := range b.failures {
+		if f.After(cutoff) {
+			kept = append(kept, f)
+		}
+	}
+	b.failures = kept
+
+	if len(b.failures) >= threshold {
+		b.openUntil = now.Add(cooldown)
+	}
+}
+
+// isFailFastError reports whether err indicates the Invoke request itself
+// was invalid, so retrying it would never help.
+func isFailFastError(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	switch aerr.Code() {
+	case lambda.ErrCodeResourceNotFoundException, lambda.ErrCodeInvalidParameterValueException:
+		return true
+	}
+	return false
+}
+
+// isRetryableError reports whether err indicates a transient condition
+// (throttling or a 5xx from Lambda/the invoked function) worth retrying.
+func isRetryableError(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	switch aerr.Code() {
+	case lambda.ErrCodeTooManyRequestsException, lambda.ErrCodeServiceException, lambda.ErrCodeEC2ThrottledException:
+		return true
+	}
+	return false
+}
+
+// backoffDelay returns how long to wait before retry attempt n (0-based)
+// under strategy. An empty or unrecognized strategy behaves like
+// RetryBackoffConstant.
+func backoffDelay(strategy string, attempt int) time.Duration {
+	switch strategy {
+	case RetryBackoffExponential:
+		return retryBackoffBase * time.Duration(1<<uint(attempt))
+	case RetryBackoffJittered:
+		d := retryBackoffBase * time.Duration(1<<uint(attempt))
+		return d/2 + time.Duration(rand.Int63n(int64(d/2+1)))
+	default:
+		return retryBackoffBase
+	}
+}
@@ -0,0 +1,226 @@
+package awslambda
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// sequenceInvoker returns canned (output, error) pairs in order, one per
+// call to Invoke, and counts how many times it was called.
+type sequenceInvoker struct {
+	replies []sequenceReply
+	calls   int
+}
+
+type sequenceReply struct {
+	out *lambda.InvokeOutput
+	err error
+}
+
+func (s *sequenceInvoker) Invoke(input *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+	r := s.replies[s.calls]
+	s.calls++
+	return r.out, r.err
+}
+
+func TestResilientInvokerRetriesThrottling(t *testing.T) {
+	inner := &sequenceInvoker{replies: []sequenceReply{
+		{err: awserr.New(lambda.ErrCodeTooManyRequestsException, "slow down", nil)},
+		{err: awserr.New(lambda.ErrCodeTooManyRequestsException, "slow down", nil)},
+		{out: &lambda.InvokeOutput{Payload: []byte("ok")}},
+	}}
+	c := &Config{MaxRetries: 2, RetryBackoff: RetryBackoffConstant}
+	inv := newResilientInvoker(inner, c)
+
+	funcName := "foo"
+	out, err := inv.Invoke(&lambda.InvokeInput{FunctionName: &funcName})
+	if err != nil {
+		t.Fatalf("Invoke returned err: %v", err)
+	}
+	if string(out.Payload) != "ok" {
+		t.Errorf("Expected payload ok, got: %s", out.Payload)
+	}
+	if inner.calls != 3 {
+		t.Errorf("Expected 3 calls to inner Invoke, got: %d", inner.calls)
+	}
+}
+
+func TestResilientInvokerFailsFastOnInvalidInput(t *testing.T) {
+	inner := &sequenceInvoker{replies: []sequenceReply{
+		{err: awserr.New(lambda.ErrCodeInvalidParameterValueException, "bad input", nil)},
+	}}
+	c := &Config{MaxRetries: 3}
+	inv := newResilientInvoker(inner, c)
+
+	funcName := "foo"
+	_, err := inv.Invoke(&lambda.InvokeInput{FunctionName: &funcName})
+	if err == nil {
+		t.Fatalf("Expected Invoke to return err")
+	}
+	if inner.calls != 1 {
+		t.Errorf("Expected 1 call to inner Invoke (no retries), got: %d", inner.calls)
+	}
+}
+
+func TestResilientInvokerCircuitBreaker(t *testing.T) {
+	inner := &sequenceInvoker{replies: []sequenceReply{
+		{err: awserr.New(lambda.ErrCodeServiceException, "boom", nil)},
+		{err: awserr.New(lambda.ErrCodeServiceException, "boom", nil)},
+		{out: &lambda.InvokeOutput{}}, // never reached while breaker is open
+	}}
+	c := &Config{BreakerFailures: 2, BreakerCooldown: time.Hour}
+	inv := newResilientInvoker(inner, c)
+	funcName := "foo"
+	input := &lambda.InvokeInput{FunctionName: &funcName}
+
+	// first two failures trip the breaker
+	for i := 0; i < 2; i++ {
+		if _, err := inv.Invoke(input); err == nil {
+			t.Fatalf("Expected Invoke %d to return err", i)
+		}
+	}
+
+	// breaker is now open; inner is not called again
+	_, err := inv.Invoke(input)
+	if err == nil || !isBreakerOpenError(err) {
+		t.Fatalf("Expected breakerOpenError, got: %v", err)
+	}
+	if inner.calls != 2 {
+		t.Errorf("Expected inner Invoke to still have been called only 2 times, got: %d", inner.calls)
+	}
+}
+
+func TestResilientInvokerCircuitBreakerHalfOpenRecovery(t *testing.T) {
+	inner := &sequenceInvoker{replies: []sequenceReply{
+		{err: awserr.New(lambda.ErrCodeServiceException, "boom", nil)},
+		{out: &lambda.InvokeOutput{Payload: []byte("recovered")}},
+	}}
+	c := &Config{BreakerFailures: 1, BreakerCooldown: time.Millisecond}
+	inv := newResilientInvoker(inner, c)
+	funcName := "foo"
+	input := &lambda.InvokeInput{FunctionName: &funcName}
+
+	if _, err := inv.Invoke(input); err == nil {
+		t.Fatalf("Expected first Invoke to return err")
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	out, err := inv.Invoke(input)
+	if err != nil {
+		t.Fatalf("Expected half-open probe to succeed, got err: %v", err)
+	}
+	if string(out.Payload) != "recovered" {
+		t.Errorf("Expected payload recovered, got: %s", out.Payload)
+	}
+}
+
+// blockingInvoker fails its first call (tripping the breaker), then
+// blocks every subsequent call on release while tracking the highest
+// number of concurrent callers it saw - used to prove only one half-open
+// probe reaches the inner Invoker at a time.
+type blockingInvoker struct {
+	release       chan struct{}
+	calls         int32
+	concurrent    int32
+	maxConcurrent int32
+}
+
+func (b *blockingInvoker) Invoke(input *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+	if atomic.AddInt32(&b.calls, 1) == 1 {
+		return nil, awserr.New(lambda.ErrCodeServiceException, "boom", nil)
+	}
+
+	cur := atomic.AddInt32(&b.concurrent, 1)
+	for {
+		max := atomic.LoadInt32(&b.maxConcurrent)
+		if cur <= max || atomic.CompareAndSwapInt32(&b.maxConcurrent, max, cur) {
+			break
+		}
+	}
+	<-b.release
+	atomic.AddInt32(&b.concurrent, -1)
+	return &lambda.InvokeOutput{}, nil
+}
+
+func TestResilientInvokerCircuitBreakerSingleProbe(t *testing.T) {
+	inner := &blockingInvoker{release: make(chan struct{})}
+	c := &Config{BreakerFailures: 1, BreakerCooldown: time.Millisecond}
+	inv := newResilientInvoker(inner, c)
+	funcName := "foo"
+	input := &lambda.InvokeInput{FunctionName: &funcName}
+
+	if _, err := inv.Invoke(input); err == nil {
+		t.Fatalf("Expected first Invoke to fail and trip the breaker")
+	}
+	time.Sleep(5 * time.Millisecond) // let the cooldown elapse
+
+	const concurrency = 20
+	var wg sync.WaitGroup
+	var openErrs int32
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := inv.Invoke(input); err != nil && isBreakerOpenError(err) {
+				atomic.AddInt32(&openErrs, 1)
+			}
+		}()
+	}
+
+	// give every goroutine a chance to reach the breaker check before
+	// releasing the one (if any) that got through
+	time.Sleep(20 * time.Millisecond)
+	close(inner.release)
+	wg.Wait()
+
+	if max := atomic.LoadInt32(&inner.maxConcurrent); max != 1 {
+		t.Errorf("Expected exactly 1 concurrent half-open probe, saw: %d", max)
+	}
+	if openErrs != concurrency-1 {
+		t.Errorf("Expected %d breakerOpenErrors, got: %d", concurrency-1, openErrs)
+	}
+}
+
+func TestResilientInvokerEvictsIdleBreakers(t *testing.T) {
+	inner := &sequenceInvoker{}
+	c := &Config{BreakerFailures: 1, BreakerCooldown: time.Minute}
+	inv := newResilientInvoker(inner, c)
+
+	now := time.Now()
+	for i := 0; i < breakerSweepThreshold; i++ {
+		inv.breakerFor(fmt.Sprintf("stale-%d", i), now.Add(-2*breakerIdleTTL))
+	}
+	if len(inv.breakers) != breakerSweepThreshold {
+		t.Fatalf("Expected %d breakers before the sweep, got: %d", breakerSweepThreshold, len(inv.breakers))
+	}
+
+	// Crossing the sweep threshold again evicts the idle entries above,
+	// leaving only the one just created.
+	inv.breakerFor("fresh", now)
+
+	if _, ok := inv.breakers["stale-0"]; ok {
+		t.Errorf("Expected idle breaker to have been evicted")
+	}
+	if len(inv.breakers) != 1 {
+		t.Errorf("Expected only the fresh breaker to remain, got: %d", len(inv.breakers))
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	if d := backoffDelay(RetryBackoffConstant, 3); d != retryBackoffBase {
+		t.Errorf("Expected constant backoff of %v, got: %v", retryBackoffBase, d)
+	}
+	if d := backoffDelay(RetryBackoffExponential, 2); d != 4*retryBackoffBase {
+		t.Errorf("Expected exponential backoff of %v, got: %v", 4*retryBackoffBase, d)
+	}
+	if d := backoffDelay(RetryBackoffJittered, 2); d < 2*retryBackoffBase || d > 4*retryBackoffBase {
+		t.Errorf("Expected jittered backoff between %v and %v, got: %v", 2*retryBackoffBase, 4*retryBackoffBase, d)
+	}
+}
@@ -0,0 +1,31 @@
+package awslambda
+
+import (
+	"github.com/mholt/caddy"
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+)
+
+func init() {
+	caddy.RegisterPlugin("awslambda", caddy.Plugin{
+		ServerType: "http",
+		Action:     setup,
+	})
+}
+
+// setup parses the awslambda Caddyfile directive and wires a Handler into
+// the middleware chain for each parsed Config.
+func setup(c *caddy.Controller) error {
+	configs, err := ParseConfigs(c)
+	if err != nil {
+		return err
+	}
+
+	httpserver.GetConfig(c).AddMiddleware(func(next httpserver.Handler) httpserver.Handler {
+		return Handler{
+			Next:    next,
+			Configs: configs,
+		}
+	})
+
+	return nil
+}